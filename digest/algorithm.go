@@ -0,0 +1,94 @@
+package digest
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"sync"
+)
+
+// Algorithm identifies a hash function used to compute a Digest, such as
+// "sha256" or "sha512". It is the value returned by Digest.Algorithm.
+type Algorithm string
+
+// ErrAlgorithmUnavailable is returned when an Algorithm has not been
+// registered with RegisterAlgorithm.
+var ErrAlgorithmUnavailable = errors.New("digest: algorithm not available")
+
+type algorithmDescriptor struct {
+	factory func() hash.Hash
+	size    int
+}
+
+var (
+	algorithmsMu sync.RWMutex
+	algorithms   = map[Algorithm]algorithmDescriptor{}
+)
+
+// RegisterAlgorithm makes a hash algorithm available to DigestVerifier and
+// newHash under name. size is the number of bytes produced by a completed
+// hash, used for Algorithm.Size. Packages that wish to verify digests
+// produced by algorithms this package does not know about by default, such
+// as blake3 or sha3, may call RegisterAlgorithm from an init function, or
+// at any later time to negotiate a newly supported algorithm at runtime —
+// it is safe to call concurrently with Algorithm.Available, Algorithm.Size
+// and DigestVerifier.
+func RegisterAlgorithm(name string, factory func() hash.Hash, size int) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+
+	algorithms[Algorithm(name)] = algorithmDescriptor{
+		factory: factory,
+		size:    size,
+	}
+}
+
+func init() {
+	RegisterAlgorithm("sha256", sha256.New, sha256.Size)
+	RegisterAlgorithm("sha1", sha1.New, sha1.Size)
+	RegisterAlgorithm("md5", md5.New, md5.Size)
+	RegisterAlgorithm("sha512", sha512.New, sha512.Size)
+}
+
+// Available returns true if the algorithm has been registered, either by
+// this package's init or by a caller of RegisterAlgorithm. Callers can use
+// this to negotiate an algorithm, preferring a stronger one when both
+// peers support it, rather than hard-coding sha256.
+func (a Algorithm) Available() bool {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	_, ok := algorithms[a]
+	return ok
+}
+
+// Size returns the number of bytes produced by a, or -1 if a is not
+// registered.
+func (a Algorithm) Size() int {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	descriptor, ok := algorithms[a]
+	if !ok {
+		return -1
+	}
+
+	return descriptor.size
+}
+
+// hash returns a new hash.Hash implementing a, or ErrAlgorithmUnavailable
+// if a has not been registered.
+func (a Algorithm) hash() (hash.Hash, error) {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	descriptor, ok := algorithms[a]
+	if !ok {
+		return nil, ErrAlgorithmUnavailable
+	}
+
+	return descriptor.factory(), nil
+}
@@ -0,0 +1,80 @@
+package digest
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/docker/docker/pkg/tarsum"
+)
+
+func sha256DigestOf(content []byte) Digest {
+	h := sha256.New()
+	h.Write(content)
+	return NewDigest("sha256", h)
+}
+
+func TestHashVerifierCheckpointRestoreRoundTrip(t *testing.T) {
+	content := []byte("the quick brown fox jumps over the lazy dog")
+	d := sha256DigestOf(content)
+	first, second := content[:10], content[10:]
+
+	whole, err := DigestVerifier(d)
+	if err != nil {
+		t.Fatalf("unexpected error from DigestVerifier: %v", err)
+	}
+	if _, err := whole.Write(content); err != nil {
+		t.Fatalf("unexpected error writing content: %v", err)
+	}
+	if !whole.Verified() {
+		t.Fatal("expected a verifier fed the whole stream at once to verify")
+	}
+
+	resumed, err := DigestVerifier(d)
+	if err != nil {
+		t.Fatalf("unexpected error from DigestVerifier: %v", err)
+	}
+	if _, err := resumed.Write(first); err != nil {
+		t.Fatalf("unexpected error writing first chunk: %v", err)
+	}
+
+	state, err := resumed.Checkpoint()
+	if err != nil {
+		t.Fatalf("unexpected error from Checkpoint: %v", err)
+	}
+
+	// Simulate resuming on a fresh upload handler that only has the
+	// persisted checkpoint, not the in-memory verifier.
+	restored, err := DigestVerifier(d)
+	if err != nil {
+		t.Fatalf("unexpected error from DigestVerifier: %v", err)
+	}
+	if err := restored.Restore(state); err != nil {
+		t.Fatalf("unexpected error from Restore: %v", err)
+	}
+
+	if _, err := restored.Write(second); err != nil {
+		t.Fatalf("unexpected error writing second chunk: %v", err)
+	}
+
+	if !restored.Verified() {
+		t.Fatal("expected a verifier restored partway through and fed the rest to verify")
+	}
+}
+
+func TestTarsumVerifierCheckpointUnsupported(t *testing.T) {
+	tarball := makeTar(t, "hello.txt", "hello, world")
+	d := tarsumOf(t, tarball, tarsum.Version1)
+
+	v, err := DigestVerifier(d)
+	if err != nil {
+		t.Fatalf("unexpected error from DigestVerifier: %v", err)
+	}
+
+	if _, err := v.Checkpoint(); err != ErrCheckpointUnsupported {
+		t.Fatalf("expected ErrCheckpointUnsupported, got %v", err)
+	}
+
+	if err := v.Restore(nil); err != ErrCheckpointUnsupported {
+		t.Fatalf("expected ErrCheckpointUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package digest
+
+import "encoding/json"
+
+// MultiVerifier verifies a single stream of content against several
+// digests, potentially of different algorithms, in one pass. It is useful
+// when migrating between digest algorithms or when producing a manifest
+// that carries more than one content address for the same blob.
+type MultiVerifier interface {
+	Verifier
+
+	// Failed returns the digests that did not match the content written to
+	// the MultiVerifier. It is only meaningful after Close has been called.
+	Failed() []Digest
+}
+
+// NewMultiVerifier returns a MultiVerifier that checks ds simultaneously.
+// Write fans out directly to each constituent Verifier without buffering;
+// the only exception is a tarsum digest, which still requires its own pipe
+// under the hood.
+func NewMultiVerifier(ds []Digest) (MultiVerifier, error) {
+	verifiers := make([]Verifier, len(ds))
+	for i, d := range ds {
+		v, err := DigestVerifier(d)
+		if err != nil {
+			return nil, err
+		}
+
+		verifiers[i] = v
+	}
+
+	return &multiVerifier{
+		digests:   ds,
+		verifiers: verifiers,
+	}, nil
+}
+
+type multiVerifier struct {
+	digests   []Digest
+	verifiers []Verifier
+}
+
+func (mv *multiVerifier) Write(p []byte) (n int, err error) {
+	for _, v := range mv.verifiers {
+		if n, err = v.Write(p); err != nil {
+			return n, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (mv *multiVerifier) Verified() bool {
+	for _, v := range mv.verifiers {
+		if !v.Verified() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (mv *multiVerifier) Failed() []Digest {
+	var failed []Digest
+
+	for i, v := range mv.verifiers {
+		if !v.Verified() {
+			failed = append(failed, mv.digests[i])
+		}
+	}
+
+	return failed
+}
+
+func (mv *multiVerifier) Err() error {
+	for _, v := range mv.verifiers {
+		if err := v.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (mv *multiVerifier) Close() error {
+	var first error
+
+	for _, v := range mv.verifiers {
+		if err := v.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+func (mv *multiVerifier) Reset() error {
+	for _, v := range mv.verifiers {
+		if err := v.Reset(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Checkpoint serializes the checkpoint of each constituent Verifier. It
+// returns ErrCheckpointUnsupported if any of them do, such as when ds
+// included a tarsum digest.
+func (mv *multiVerifier) Checkpoint() ([]byte, error) {
+	states := make([][]byte, len(mv.verifiers))
+
+	for i, v := range mv.verifiers {
+		state, err := v.Checkpoint()
+		if err != nil {
+			return nil, err
+		}
+
+		states[i] = state
+	}
+
+	return json.Marshal(states)
+}
+
+// Restore rehydrates a checkpoint produced by Checkpoint, restoring each
+// constituent Verifier in turn. If it returns an error partway through,
+// the constituent Verifiers restored so far are left in their new,
+// checkpointed state while the rest are untouched; the MultiVerifier as a
+// whole should be discarded rather than reused after a failed Restore.
+func (mv *multiVerifier) Restore(state []byte) error {
+	var states [][]byte
+	if err := json.Unmarshal(state, &states); err != nil {
+		return err
+	}
+
+	if len(states) != len(mv.verifiers) {
+		return ErrCheckpointUnsupported
+	}
+
+	for i, v := range mv.verifiers {
+		if err := v.Restore(states[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package digest
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/tarsum"
+)
+
+// makeTar returns a minimal, valid tar archive containing a single file.
+func makeTar(t *testing.T, name, body string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(body))}); err != nil {
+		t.Fatalf("unexpected error writing tar header: %v", err)
+	}
+
+	if _, err := tw.Write([]byte(body)); err != nil {
+		t.Fatalf("unexpected error writing tar body: %v", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unexpected error closing tar writer: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+// tarsumOf computes the tarsum digest of tarball under version, the same
+// way a caller producing a reference digest would.
+func tarsumOf(t *testing.T, tarball []byte, version tarsum.Version) Digest {
+	ts, err := tarsum.NewTarSum(bytes.NewReader(tarball), true, version)
+	if err != nil {
+		t.Fatalf("unexpected error creating tarsum: %v", err)
+	}
+
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		t.Fatalf("unexpected error draining tarsum: %v", err)
+	}
+
+	return Digest(ts.Sum(nil))
+}
+
+// closeWithTimeout calls v.Close() in a goroutine and fails the test if it
+// has not returned within d, guarding against the tarsumVerifier regressing
+// back to the deadlock class of bug chunk0-1 fixed.
+func closeWithTimeout(t *testing.T, v Verifier, d time.Duration) error {
+	t.Helper()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- v.Close()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(d):
+		t.Fatal("Close did not return, drain goroutine likely leaked")
+		return nil
+	}
+}
+
+func TestTarsumVerifierCloseTerminatesDrainGoroutine(t *testing.T) {
+	tarball := makeTar(t, "hello.txt", "hello, world")
+	d := tarsumOf(t, tarball, tarsum.Version1)
+
+	v, err := DigestVerifier(d)
+	if err != nil {
+		t.Fatalf("unexpected error from DigestVerifier: %v", err)
+	}
+
+	if _, err := v.Write(tarball); err != nil {
+		t.Fatalf("unexpected error writing tarball: %v", err)
+	}
+
+	if err := closeWithTimeout(t, v, time.Second); err != nil {
+		t.Fatalf("unexpected error from Close: %v", err)
+	}
+
+	if err := v.Err(); err != nil {
+		t.Fatalf("unexpected error from Err: %v", err)
+	}
+
+	if !v.Verified() {
+		t.Fatal("expected verifier to report content as verified")
+	}
+}
+
+func TestTarsumVerifierSurfacesParseErrorThroughErrAndClose(t *testing.T) {
+	// A well-formed tarsum digest string, but the content written below is
+	// not a valid tar stream, so the drain goroutine should fail to parse
+	// it and capture that failure.
+	d := Digest("tarsum.v1+sha256:" + strings.Repeat("0", 64))
+
+	v, err := DigestVerifier(d)
+	if err != nil {
+		t.Fatalf("unexpected error from DigestVerifier: %v", err)
+	}
+
+	if _, err := v.Write([]byte("this is not a tar archive")); err != nil {
+		// The pipe may already have been closed with the parse error by
+		// the time this Write happens; either way is acceptable here.
+		_ = err
+	}
+
+	if err := closeWithTimeout(t, v, time.Second); err == nil {
+		t.Fatal("expected Close to surface the tarsum parse error")
+	}
+
+	if v.Err() == nil {
+		t.Fatal("expected Err to return the tarsum parse error")
+	}
+
+	if v.Verified() {
+		t.Fatal("expected Verified to be false after a parse error")
+	}
+}
+
+func TestTarsumVerifierResetWithoutCloseErrors(t *testing.T) {
+	tarball := makeTar(t, "hello.txt", "hello, world")
+	d := tarsumOf(t, tarball, tarsum.Version1)
+
+	v, err := DigestVerifier(d)
+	if err != nil {
+		t.Fatalf("unexpected error from DigestVerifier: %v", err)
+	}
+
+	if err := v.Reset(); err != ErrVerifierRunning {
+		t.Fatalf("expected ErrVerifierRunning from Reset before Close, got %v", err)
+	}
+
+	if err := closeWithTimeout(t, v, time.Second); err != nil {
+		t.Fatalf("unexpected error from Close: %v", err)
+	}
+
+	if err := v.Reset(); err != nil {
+		t.Fatalf("unexpected error from Reset after Close: %v", err)
+	}
+
+	if err := v.Close(); err != nil {
+		t.Fatalf("unexpected error from Close after Reset: %v", err)
+	}
+}
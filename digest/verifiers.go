@@ -1,9 +1,9 @@
 package digest
 
 import (
-	"crypto/md5"
-	"crypto/sha1"
-	"crypto/sha256"
+	"encoding"
+	"encoding/json"
+	"errors"
 	"hash"
 	"io"
 	"io/ioutil"
@@ -11,59 +11,89 @@ import (
 	"github.com/docker/docker/pkg/tarsum"
 )
 
+// ErrVerifierRunning is returned by the tarsum-backed Verifier's Reset if it
+// is called before Close, guarding against abandoning its drain goroutine
+// while it is still running. The hash and length Verifiers hold no
+// background resources and Reset them without this check.
+var ErrVerifierRunning = errors.New("digest: verifier must be closed before it can be reset")
+
+// ErrCheckpointUnsupported is returned by Checkpoint or Restore when a
+// Verifier has no way to serialize or resume its internal state, such as
+// the tarsum-backed Verifier, whose state lives inside a running
+// goroutine.
+var ErrCheckpointUnsupported = errors.New("digest: checkpoint unsupported")
+
+// Verifier is a type which can validate a stream of content against a
+// digest. A single instance may be used across multiple Writes, but it
+// must be Closed (and, if reuse is desired, Reset) before the underlying
+// resources can be released or recycled.
 type Verifier interface {
 	io.Writer
+	io.Closer
 
 	// Verified will return true if the content written to Verifier matches
 	// the digest.
 	Verified() bool
 
-	// Planned methods:
-	// Err() error
-	// Reset()
-}
+	// Err returns any error encountered while computing the digest, such as
+	// a parse error surfaced asynchronously by a background goroutine. It
+	// should be checked after Close returns, in addition to Close's own
+	// return value.
+	Err() error
 
-func DigestVerifier(d Digest) Verifier {
-	alg := d.Algorithm()
-	switch alg {
-	case "md5", "sha1", "sha256":
-		return hashVerifier{
-			hash:   newHash(alg),
-			digest: d,
-		}
-	default:
-		// Assume we have a tarsum.
-		version, err := tarsum.GetVersionFromTarsum(string(d))
-		if err != nil {
-			panic(err) // Always assume valid tarsum at this point.
-		}
+	// Reset discards any state accumulated by previous Writes, re-readying
+	// the Verifier to validate a fresh stream against the same digest.
+	// Callers should call Close first; a tarsum Verifier returns
+	// ErrVerifierRunning if it has not been closed.
+	Reset() error
 
-		pr, pw := io.Pipe()
+	// Checkpoint serializes the Verifier's state so that an equivalent
+	// Verifier can later be resumed with Restore, without re-reading the
+	// bytes already written. It returns ErrCheckpointUnsupported if the
+	// Verifier cannot be serialized, which is always true of a tarsum
+	// Verifier.
+	Checkpoint() ([]byte, error)
 
-		// TODO(stevvooe): We may actually want to ban the earlier versions of
-		// tarsum. That decision may not be the place of the verifier.
+	// Restore rehydrates state previously produced by Checkpoint. It
+	// returns ErrCheckpointUnsupported under the same conditions as
+	// Checkpoint.
+	Restore(state []byte) error
+}
 
-		ts, err := tarsum.NewTarSum(pr, true, version)
+// DigestVerifier returns a Verifier that validates content against d. If
+// d's algorithm is registered (see RegisterAlgorithm), the returned
+// Verifier hashes the content directly; otherwise, d is assumed to be a
+// tarsum and a tarsumVerifier is returned.
+func DigestVerifier(d Digest) (Verifier, error) {
+	alg := d.Algorithm()
+	if alg.Available() {
+		h, err := newHash(alg)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
-		// TODO(sday): Ick! A goroutine per digest verification? We'll have to
-		// get the tarsum library to export an io.Writer variant.
-		go func() {
-			io.Copy(ioutil.Discard, ts)
-			pw.Close()
-		}()
-
-		return &tarsumVerifier{
+		return &hashVerifier{
+			hash:   h,
 			digest: d,
-			ts:     ts,
-			pr:     pr,
-			pw:     pw,
-		}
+		}, nil
+	}
+
+	// Assume we have a tarsum. Unlike before algorithms were pluggable, an
+	// unregistered alg is now an expected case (a typo or an algorithm the
+	// caller forgot to register), not just a malformed tarsum, so report it
+	// as an error rather than panicking.
+	version, err := tarsum.GetVersionFromTarsum(string(d))
+	if err != nil {
+		return nil, err
+	}
+
+	tv := &tarsumVerifier{
+		digest:  d,
+		version: version,
 	}
+	tv.start()
 
-	panic("unsupported digest: " + d)
+	return tv, nil
 }
 
 // LengthVerifier returns a verifier that returns true when the number of read
@@ -89,37 +119,169 @@ func (lv *lengthVerifier) Verified() bool {
 	return lv.expected == lv.len
 }
 
-func newHash(name string) hash.Hash {
-	switch name {
-	case "sha256":
-		return sha256.New()
-	case "sha1":
-		return sha1.New()
-	case "md5":
-		return md5.New()
-	default:
-		panic("unsupport algorithm: " + name)
-	}
+func (lv *lengthVerifier) Err() error {
+	return nil
+}
+
+func (lv *lengthVerifier) Close() error {
+	return nil
+}
+
+func (lv *lengthVerifier) Reset() error {
+	lv.len = 0
+	return nil
+}
+
+func (lv *lengthVerifier) Checkpoint() ([]byte, error) {
+	return json.Marshal(lv.len)
+}
+
+func (lv *lengthVerifier) Restore(state []byte) error {
+	return json.Unmarshal(state, &lv.len)
+}
+
+// newHash returns a new hash.Hash for the registered Algorithm name, or
+// ErrAlgorithmUnavailable if name has not been registered.
+func newHash(name Algorithm) (hash.Hash, error) {
+	return name.hash()
 }
 
 type hashVerifier struct {
-	digest Digest
-	hash   hash.Hash
+	digest   Digest
+	hash     hash.Hash
+	consumed int64 // bytes written so far, persisted across checkpoints
 }
 
-func (hv hashVerifier) Write(p []byte) (n int, err error) {
-	return hv.hash.Write(p)
+// hashVerifierCheckpoint is the wire format persisted by
+// hashVerifier.Checkpoint. Algorithm guards against restoring into a
+// Verifier constructed for a different digest algorithm.
+type hashVerifierCheckpoint struct {
+	Algorithm Algorithm
+	Consumed  int64
+	State     []byte
+}
+
+func (hv *hashVerifier) Write(p []byte) (n int, err error) {
+	n, err = hv.hash.Write(p)
+	hv.consumed += int64(n)
+	return n, err
 }
 
-func (hv hashVerifier) Verified() bool {
+func (hv *hashVerifier) Verified() bool {
 	return hv.digest == NewDigest(hv.digest.Algorithm(), hv.hash)
 }
 
+func (hv *hashVerifier) Err() error {
+	return nil
+}
+
+func (hv *hashVerifier) Close() error {
+	return nil
+}
+
+func (hv *hashVerifier) Reset() error {
+	hv.hash.Reset()
+	hv.consumed = 0
+	return nil
+}
+
+// Checkpoint serializes the underlying hash state using
+// encoding.BinaryMarshaler, which the stdlib sha256, sha1 and md5 hashes
+// all implement. It returns ErrCheckpointUnsupported if hv.hash does not
+// implement encoding.BinaryMarshaler.
+func (hv *hashVerifier) Checkpoint() ([]byte, error) {
+	marshaler, ok := hv.hash.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, ErrCheckpointUnsupported
+	}
+
+	state, err := marshaler.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(hashVerifierCheckpoint{
+		Algorithm: hv.digest.Algorithm(),
+		Consumed:  hv.consumed,
+		State:     state,
+	})
+}
+
+// Restore rehydrates a checkpoint produced by Checkpoint, so that an
+// upload handler can resume a partially-fed Verifier on the next chunk
+// instead of re-reading previously uploaded bytes from the blob store.
+func (hv *hashVerifier) Restore(state []byte) error {
+	unmarshaler, ok := hv.hash.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return ErrCheckpointUnsupported
+	}
+
+	var checkpoint hashVerifierCheckpoint
+	if err := json.Unmarshal(state, &checkpoint); err != nil {
+		return err
+	}
+
+	if checkpoint.Algorithm != hv.digest.Algorithm() {
+		return ErrAlgorithmUnavailable
+	}
+
+	if err := unmarshaler.UnmarshalBinary(checkpoint.State); err != nil {
+		return err
+	}
+
+	hv.consumed = checkpoint.Consumed
+	return nil
+}
+
+// tarsumVerifier verifies a tarsum digest. Because the tarsum library only
+// exposes an io.Writer that must be drained through a goroutine, Write
+// pushes bytes through a pipe into the tarsum while a background goroutine
+// reads the resulting stream to ioutil.Discard, capturing any error it
+// encounters for later retrieval through Err.
 type tarsumVerifier struct {
-	digest Digest
-	ts     tarsum.TarSum
-	pr     *io.PipeReader
-	pw     *io.PipeWriter
+	digest  Digest
+	version tarsum.Version
+
+	ts tarsum.TarSum
+	pr *io.PipeReader
+	pw *io.PipeWriter
+
+	done    chan struct{} // closed once the drain goroutine has exited
+	err     error         // error from the drain goroutine, set before done is closed
+	running bool          // true from start until Close has reaped the drain goroutine
+}
+
+// start (re)initializes the pipe, tarsum and drain goroutine. It must only
+// be called when the verifier is not already running.
+func (tv *tarsumVerifier) start() {
+	pr, pw := io.Pipe()
+
+	// TODO(stevvooe): We may actually want to ban the earlier versions of
+	// tarsum. That decision may not be the place of the verifier.
+
+	ts, err := tarsum.NewTarSum(pr, true, tv.version)
+	if err != nil {
+		panic(err)
+	}
+
+	tv.ts = ts
+	tv.pr = pr
+	tv.pw = pw
+	tv.err = nil
+	tv.done = make(chan struct{})
+	tv.running = true
+
+	// TODO(sday): Ick! A goroutine per digest verification? We'll have to
+	// get the tarsum library to export an io.Writer variant.
+	go func() {
+		defer close(tv.done)
+
+		if _, err := io.Copy(ioutil.Discard, tv.ts); err != nil {
+			tv.err = err
+			pr.CloseWithError(err)
+			return
+		}
+	}()
 }
 
 func (tv *tarsumVerifier) Write(p []byte) (n int, err error) {
@@ -127,5 +289,49 @@ func (tv *tarsumVerifier) Write(p []byte) (n int, err error) {
 }
 
 func (tv *tarsumVerifier) Verified() bool {
-	return tv.digest == Digest(tv.ts.Sum(nil))
+	return tv.err == nil && tv.digest == Digest(tv.ts.Sum(nil))
+}
+
+func (tv *tarsumVerifier) Err() error {
+	return tv.err
+}
+
+// Close shuts down the pipe, on either the success or failure path, and
+// waits for the drain goroutine to exit before returning. This avoids the
+// class of deadlock bugs where the goroutine is left blocked on a pipe that
+// nothing is going to close.
+func (tv *tarsumVerifier) Close() error {
+	if err := tv.pw.Close(); err != nil {
+		return err
+	}
+
+	<-tv.done
+	tv.running = false
+
+	return tv.err
+}
+
+// Reset re-initializes the tarsum state so that the verifier may be reused
+// to validate another stream against the same digest. Close must be called
+// before Reset; otherwise Reset returns ErrVerifierRunning rather than
+// abandoning the still-running drain goroutine.
+func (tv *tarsumVerifier) Reset() error {
+	if tv.running {
+		return ErrVerifierRunning
+	}
+
+	tv.start()
+	return nil
+}
+
+// Checkpoint always returns ErrCheckpointUnsupported: tarsum state lives
+// inside the running drain goroutine and the tarsum library exposes no way
+// to serialize or resume it.
+func (tv *tarsumVerifier) Checkpoint() ([]byte, error) {
+	return nil, ErrCheckpointUnsupported
+}
+
+// Restore always returns ErrCheckpointUnsupported. See Checkpoint.
+func (tv *tarsumVerifier) Restore(state []byte) error {
+	return ErrCheckpointUnsupported
 }
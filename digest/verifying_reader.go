@@ -0,0 +1,187 @@
+package digest
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrDigestMismatch is returned from Read when the total bytes read do not
+// hash to the expected digest.
+var ErrDigestMismatch = errors.New("digest: content does not match digest")
+
+// ErrSizeMismatch is returned from Read when the total number of bytes read
+// does not match the expected size.
+var ErrSizeMismatch = errors.New("digest: content does not match size")
+
+// NewVerifyingReader wraps r so that every byte read from it is verified
+// against d and size as it passes through. The wrapped Read only returns
+// io.EOF once the underlying reader has returned io.EOF and both the byte
+// count and the digest match; otherwise it returns ErrDigestMismatch or
+// ErrSizeMismatch at the point io.EOF would have been returned. Pass
+// size == -1 to verify only the digest, ignoring length.
+//
+// This allows a registry blob body to be handed directly to a consumer,
+// such as tar.NewReader, without requiring a second, separate verification
+// pass over the same bytes.
+func NewVerifyingReader(r io.ReadCloser, d Digest, size int64) (io.ReadCloser, error) {
+	digest, err := DigestVerifier(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifyingReader{
+		r:      r,
+		digest: digest,
+		length: LengthVerifier(size),
+		size:   size,
+	}, nil
+}
+
+// NewVerifyingWriter returns a WriteCloser with the same verification
+// semantics as NewVerifyingReader: Close returns ErrDigestMismatch or
+// ErrSizeMismatch if the bytes written do not match d and size.
+func NewVerifyingWriter(w io.WriteCloser, d Digest, size int64) (io.WriteCloser, error) {
+	digest, err := DigestVerifier(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifyingWriter{
+		w:      w,
+		digest: digest,
+		length: LengthVerifier(size),
+		size:   size,
+	}, nil
+}
+
+type verifyingReader struct {
+	r      io.ReadCloser
+	digest Verifier
+	length Verifier
+	size   int64
+}
+
+func (vr *verifyingReader) Read(p []byte) (n int, err error) {
+	n, err = vr.r.Read(p)
+
+	if n > 0 {
+		if _, werr := vr.digest.Write(p[:n]); werr != nil {
+			return n, werr
+		}
+
+		if vr.size >= 0 {
+			vr.length.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF {
+		if vr.size >= 0 && !vr.length.Verified() {
+			return n, ErrSizeMismatch
+		}
+
+		// Closing the digest Verifier lets a tarsum-backed one finish
+		// computing the digest before it is checked.
+		if cerr := vr.digest.Close(); cerr != nil {
+			return n, cerr
+		}
+
+		if !vr.digest.Verified() {
+			return n, ErrDigestMismatch
+		}
+	}
+
+	return n, err
+}
+
+// Close closes the wrapped Verifiers, most importantly allowing a
+// tarsum-backed digest Verifier to shut down its drain goroutine, in
+// addition to closing r. Close folds any error surfaced by the Verifiers
+// (including one recorded asynchronously and retrieved through Err) into
+// its return value, rather than relying solely on Verified, since the
+// tarsum drain may not have finished computing the digest until Close has
+// run.
+func (vr *verifyingReader) Close() error {
+	err := vr.r.Close()
+
+	if derr := vr.digest.Close(); derr != nil {
+		if err == nil {
+			err = derr
+		}
+	} else if derr := vr.digest.Err(); derr != nil && err == nil {
+		err = derr
+	}
+
+	if lerr := vr.length.Close(); lerr != nil && err == nil {
+		err = lerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if vr.size >= 0 && !vr.length.Verified() {
+		return ErrSizeMismatch
+	}
+
+	if !vr.digest.Verified() {
+		return ErrDigestMismatch
+	}
+
+	return nil
+}
+
+type verifyingWriter struct {
+	w      io.WriteCloser
+	digest Verifier
+	length Verifier
+	size   int64
+}
+
+func (vw *verifyingWriter) Write(p []byte) (n int, err error) {
+	n, err = vw.w.Write(p)
+
+	if n > 0 {
+		if _, werr := vw.digest.Write(p[:n]); werr != nil {
+			return n, werr
+		}
+
+		if vw.size >= 0 {
+			vw.length.Write(p[:n])
+		}
+	}
+
+	return n, err
+}
+
+// Close closes the wrapped Verifiers before checking them, for the same
+// reason as verifyingReader.Close: a tarsum-backed digest Verifier must be
+// closed to stop its drain goroutine and to let it finish computing the
+// digest, and any error it surfaces through Close or Err takes precedence
+// over Verified.
+func (vw *verifyingWriter) Close() error {
+	var err error
+
+	if derr := vw.digest.Close(); derr != nil {
+		err = derr
+	} else if derr := vw.digest.Err(); derr != nil {
+		err = derr
+	}
+
+	if lerr := vw.length.Close(); lerr != nil && err == nil {
+		err = lerr
+	}
+
+	if err == nil {
+		if vw.size >= 0 && !vw.length.Verified() {
+			err = ErrSizeMismatch
+		} else if !vw.digest.Verified() {
+			err = ErrDigestMismatch
+		}
+	}
+
+	if werr := vw.w.Close(); werr != nil && err == nil {
+		err = werr
+	}
+
+	return err
+}